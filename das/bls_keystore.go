@@ -0,0 +1,244 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/offchainlabs/nitro/blsSignatures"
+)
+
+// KeystoreFormat selects how the BLS signing key is persisted on disk.
+const (
+	KeystoreFormatPlaintext = "plaintext"
+	KeystoreFormatV3        = "v3"
+)
+
+const (
+	keystoreCipher   = "aes-128-ctr"
+	keystoreKDFName  = "scrypt"
+	keystoreVersion  = 3
+	keystoreSaltLen  = 32
+	keystoreDKLen    = 32
+	keystoreCredsDir = "CREDENTIALS_DIRECTORY"
+)
+
+var (
+	DefaultKeystoreScryptN = 1 << 18
+	DefaultKeystoreScryptR = 8
+	DefaultKeystoreScryptP = 1
+
+	ErrDecryptKeystore = errors.New("could not decrypt key with given passphrase")
+)
+
+// encryptedKeyJSON is the on-disk representation of a BLS private key encrypted
+// with a Web3 Secret Storage (keystore v3) style scheme.
+type encryptedKeyJSON struct {
+	PublicKey string     `json:"publickey"`
+	Crypto    cryptoJSON `json:"crypto"`
+	Version   int        `json:"version"`
+}
+
+type cryptoJSON struct {
+	Cipher       string       `json:"cipher"`
+	CipherText   string       `json:"ciphertext"`
+	CipherParams cipherParams `json:"cipherparams"`
+	KDF          string       `json:"kdf"`
+	KDFParams    kdfParams    `json:"kdfparams"`
+	MAC          string       `json:"mac"`
+}
+
+type cipherParams struct {
+	IV string `json:"iv"`
+}
+
+type kdfParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// EncryptBLSKey encrypts privKey with passphrase using scrypt (with the given
+// N/r/p cost parameters) to derive a symmetric key, aes-128-ctr to encrypt the
+// key material, and Keccak256(derivedKey[16:32] || ciphertext) as a MAC, in
+// the style of Ethereum's Web3 Secret Storage keystore v3 format.
+func EncryptBLSKey(privKey *blsSignatures.PrivateKey, passphrase string, scryptN, scryptR, scryptP int) ([]byte, error) {
+	pubKey, err := blsSignatures.PublicKeyFromPrivateKey(*privKey)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, keystoreSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keystoreDKLen)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt key derivation failed: %w", err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derivedKey[:16])
+	if err != nil {
+		return nil, err
+	}
+	plainKey := blsSignatures.PrivateKeyToBytes(*privKey)
+	cipherText := make([]byte, len(plainKey))
+	cipher.NewCTR(block, iv).XORKeyStream(cipherText, plainKey)
+
+	mac := crypto.Keccak256(derivedKey[16:32], cipherText)
+
+	keyJSON := encryptedKeyJSON{
+		PublicKey: base64.StdEncoding.EncodeToString(blsSignatures.PublicKeyToBytes(pubKey)),
+		Version:   keystoreVersion,
+		Crypto: cryptoJSON{
+			Cipher:       keystoreCipher,
+			CipherText:   base64.StdEncoding.EncodeToString(cipherText),
+			CipherParams: cipherParams{IV: base64.StdEncoding.EncodeToString(iv)},
+			KDF:          keystoreKDFName,
+			KDFParams: kdfParams{
+				N:     scryptN,
+				R:     scryptR,
+				P:     scryptP,
+				DKLen: keystoreDKLen,
+				Salt:  base64.StdEncoding.EncodeToString(salt),
+			},
+			MAC: base64.StdEncoding.EncodeToString(mac),
+		},
+	}
+
+	return json.MarshalIndent(keyJSON, "", "  ")
+}
+
+// LoadEncryptedBLSKey decrypts a keystore v3 style JSON blob produced by
+// EncryptBLSKey, recovering the BLS private key.
+func LoadEncryptedBLSKey(keystoreJSON []byte, passphrase string) (*blsSignatures.PrivateKey, error) {
+	var keyJSON encryptedKeyJSON
+	if err := json.Unmarshal(keystoreJSON, &keyJSON); err != nil {
+		return nil, fmt.Errorf("invalid keystore file: %w", err)
+	}
+	if keyJSON.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d", keyJSON.Version)
+	}
+	if keyJSON.Crypto.Cipher != keystoreCipher {
+		return nil, fmt.Errorf("unsupported cipher %q", keyJSON.Crypto.Cipher)
+	}
+	if keyJSON.Crypto.KDF != keystoreKDFName {
+		return nil, fmt.Errorf("unsupported kdf %q", keyJSON.Crypto.KDF)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(keyJSON.Crypto.KDFParams.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %w", err)
+	}
+	iv, err := base64.StdEncoding.DecodeString(keyJSON.Crypto.CipherParams.IV)
+	if err != nil {
+		return nil, fmt.Errorf("invalid iv: %w", err)
+	}
+	cipherText, err := base64.StdEncoding.DecodeString(keyJSON.Crypto.CipherText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+	wantMAC, err := base64.StdEncoding.DecodeString(keyJSON.Crypto.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mac: %w", err)
+	}
+
+	kdf := keyJSON.Crypto.KDFParams
+	if kdf.N <= 1 || kdf.R <= 0 || kdf.P <= 0 || kdf.DKLen < keystoreDKLen {
+		return nil, fmt.Errorf("%w: implausible scrypt parameters", ErrDecryptKeystore)
+	}
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, kdf.N, kdf.R, kdf.P, kdf.DKLen)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt key derivation failed: %w", err)
+	}
+
+	gotMAC := crypto.Keccak256(derivedKey[16:32], cipherText)
+	if !bytes.Equal(gotMAC, wantMAC) {
+		return nil, ErrDecryptKeystore
+	}
+
+	block, err := aes.NewCipher(derivedKey[:16])
+	if err != nil {
+		return nil, err
+	}
+	plainKey := make([]byte, len(cipherText))
+	cipher.NewCTR(block, iv).XORKeyStream(plainKey, cipherText)
+
+	privKey, err := blsSignatures.PrivateKeyFromBytes(plainKey)
+	if err != nil {
+		return nil, ErrDecryptKeystore
+	}
+	return &privKey, nil
+}
+
+// GenerateAndStoreEncryptedKeys generates a new BLS keypair and writes the
+// public key in plaintext (DefaultPubKeyFilename) and the private key
+// encrypted with passphrase (DefaultPrivKeyFilename) to dir, analogous to
+// GenerateAndStoreKeys but at rest the private key is never written in the
+// clear.
+func GenerateAndStoreEncryptedKeys(dir string, passphrase string, scryptN, scryptR, scryptP int) (*blsSignatures.PublicKey, *blsSignatures.PrivateKey, error) {
+	pubKey, privKey, err := blsSignatures.GenerateKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, err
+	}
+
+	keystoreJSON, err := EncryptBLSKey(&privKey, passphrase, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, DefaultPrivKeyFilename), keystoreJSON, 0600); err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, DefaultPubKeyFilename), []byte(base64.StdEncoding.EncodeToString(blsSignatures.PublicKeyToBytes(pubKey))), 0644); err != nil {
+		return nil, nil, err
+	}
+
+	return &pubKey, &privKey, nil
+}
+
+// readPassphrase resolves the configured passphrase, preferring an explicit
+// PassphraseFile (which may be a systemd LoadCredential path) over the
+// inline Passphrase value, so the passphrase need not be embedded in koanf
+// config.
+func readPassphrase(config StorageConfig) (string, error) {
+	if config.PassphraseFile != "" {
+		path := config.PassphraseFile
+		if !filepath.IsAbs(path) {
+			if credsDir := os.Getenv(keystoreCredsDir); credsDir != "" {
+				path = filepath.Join(credsDir, path)
+			}
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("could not read passphrase-file %q: %w", config.PassphraseFile, err)
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	}
+	if config.Passphrase != "" {
+		return config.Passphrase, nil
+	}
+	return "", errors.New("encrypted keystore requires either 'passphrase' or 'passphrase-file' to be set")
+}
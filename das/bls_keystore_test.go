@@ -0,0 +1,102 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/offchainlabs/nitro/blsSignatures"
+)
+
+func TestEncryptDecryptBLSKeyRoundTrip(t *testing.T) {
+	_, privKey, err := blsSignatures.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystoreJSON, err := EncryptBLSKey(&privKey, "correct horse battery staple", 1<<12, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadEncryptedBLSKey(keystoreJSON, "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("unexpected error decrypting with the correct passphrase: %v", err)
+	}
+	if base64.StdEncoding.EncodeToString(blsSignatures.PrivateKeyToBytes(*got)) !=
+		base64.StdEncoding.EncodeToString(blsSignatures.PrivateKeyToBytes(privKey)) {
+		t.Fatal("decrypted private key does not match the original")
+	}
+}
+
+func TestLoadEncryptedBLSKeyWrongPassphrase(t *testing.T) {
+	_, privKey, err := blsSignatures.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystoreJSON, err := EncryptBLSKey(&privKey, "correct horse battery staple", 1<<12, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadEncryptedBLSKey(keystoreJSON, "wrong passphrase"); !errors.Is(err, ErrDecryptKeystore) {
+		t.Fatalf("expected ErrDecryptKeystore for a wrong passphrase, got %v", err)
+	}
+}
+
+func TestLoadEncryptedBLSKeyCorruptedMAC(t *testing.T) {
+	_, privKey, err := blsSignatures.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystoreJSON, err := EncryptBLSKey(&privKey, "correct horse battery staple", 1<<12, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keyJSON encryptedKeyJSON
+	if err := json.Unmarshal(keystoreJSON, &keyJSON); err != nil {
+		t.Fatal(err)
+	}
+	keyJSON.Crypto.MAC = base64.StdEncoding.EncodeToString([]byte("not the right mac at all, 32 b."))
+	corrupted, err := json.Marshal(keyJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadEncryptedBLSKey(corrupted, "correct horse battery staple"); !errors.Is(err, ErrDecryptKeystore) {
+		t.Fatalf("expected ErrDecryptKeystore for a corrupted MAC, got %v", err)
+	}
+}
+
+func TestLoadEncryptedBLSKeyImplausibleScryptParams(t *testing.T) {
+	_, privKey, err := blsSignatures.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keystoreJSON, err := EncryptBLSKey(&privKey, "correct horse battery staple", 1<<12, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keyJSON encryptedKeyJSON
+	if err := json.Unmarshal(keystoreJSON, &keyJSON); err != nil {
+		t.Fatal(err)
+	}
+	keyJSON.Crypto.KDFParams.DKLen = 8
+	tampered, err := json.Marshal(keyJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadEncryptedBLSKey(tampered, "correct horse battery staple"); !errors.Is(err, ErrDecryptKeystore) {
+		t.Fatalf("expected a truncated dklen to be rejected as ErrDecryptKeystore, got %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+
+	flag "github.com/spf13/pflag"
+)
+
+// ErrNotFound is returned by a StorageService when no data is found for the
+// requested hash.
+var ErrNotFound = errors.New("not found")
+
+type IPFSConfig struct {
+	Enable            bool          `koanf:"enable"`
+	Filecoin          bool          `koanf:"filecoin"`
+	APIEndpoint       string        `koanf:"api-endpoint"`
+	APIToken          string        `koanf:"api-token"`
+	PinOnPut          bool          `koanf:"pin-on-put"`
+	ReplicationFactor int           `koanf:"replication-factor"`
+	RetryAttempts     int           `koanf:"retry-attempts"`
+	RetryBackoff      time.Duration `koanf:"retry-backoff"`
+	DealClientURL     string        `koanf:"deal-client-url"`
+	DealClientToken   string        `koanf:"deal-client-token"`
+	DealMinerAddress  string        `koanf:"deal-miner-address"`
+	CIDIndexDir       string        `koanf:"cid-index-dir"`
+}
+
+var DefaultIPFSConfig = IPFSConfig{
+	Enable:            false,
+	Filecoin:          false,
+	APIEndpoint:       "http://localhost:5001",
+	APIToken:          "",
+	PinOnPut:          true,
+	ReplicationFactor: 1,
+	RetryAttempts:     3,
+	RetryBackoff:      time.Second,
+	DealClientURL:     "",
+	DealClientToken:   "",
+	DealMinerAddress:  "",
+	CIDIndexDir:       "",
+}
+
+func IPFSConfigAddOptions(prefix string, f *flag.FlagSet) {
+	f.Bool(prefix+".enable", DefaultIPFSConfig.Enable, "Enable storage/retrieval of sequencer batch data from an IPFS/Filecoin backend")
+	f.Bool(prefix+".filecoin", DefaultIPFSConfig.Filecoin, "Additionally place a Filecoin storage deal for every pinned CID")
+	f.String(prefix+".api-endpoint", DefaultIPFSConfig.APIEndpoint, "The URL of the IPFS (Kubo) HTTP RPC API to pin data to")
+	f.String(prefix+".api-token", DefaultIPFSConfig.APIToken, "Bearer token for the IPFS HTTP RPC API, if required")
+	f.Bool(prefix+".pin-on-put", DefaultIPFSConfig.PinOnPut, "Pin the root CID of every message put to the DAS")
+	f.Int(prefix+".replication-factor", DefaultIPFSConfig.ReplicationFactor, "The number of Filecoin miners (from 'deal-miner-address', comma-separated) to place a storage deal with for each CID")
+	f.Int(prefix+".retry-attempts", DefaultIPFSConfig.RetryAttempts, "The number of times to retry a failed pin before giving up")
+	f.Duration(prefix+".retry-backoff", DefaultIPFSConfig.RetryBackoff, "The base backoff duration between pin retries")
+	f.String(prefix+".deal-client-url", DefaultIPFSConfig.DealClientURL, "JSON-RPC URL of the Filecoin storage-deal client (Boost/Lotus), used when 'filecoin' is enabled")
+	f.String(prefix+".deal-client-token", DefaultIPFSConfig.DealClientToken, "Auth token for the storage-deal client JSON-RPC endpoint")
+	f.String(prefix+".deal-miner-address", DefaultIPFSConfig.DealMinerAddress, "Comma-separated Filecoin miner addresses to target for storage deals")
+	f.String(prefix+".cid-index-dir", DefaultIPFSConfig.CIDIndexDir, "Directory to persist the Keccak256-hash-to-CID index in, so GetByHash survives a restart")
+}
+
+// IPFSStorageService stores data availability messages in an IPFS (and
+// optionally Filecoin) backed DAG, keeping a local mapping from the
+// Keccak256 hash the DAS protocol indexes by to the CID that IPFS/Filecoin
+// indexes by.
+type IPFSStorageService struct {
+	config IPFSConfig
+	client *http.Client
+
+	mu        sync.RWMutex
+	hashToCid map[common.Hash]string
+}
+
+func NewIPFSStorageService(config IPFSConfig) (*IPFSStorageService, error) {
+	if !config.Enable {
+		return nil, fmt.Errorf("IPFS storage tier requires 'enable' to be set")
+	}
+	if config.APIEndpoint == "" {
+		return nil, fmt.Errorf("IPFS api-endpoint must be set")
+	}
+	if config.CIDIndexDir != "" {
+		if err := os.MkdirAll(config.CIDIndexDir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create cid-index-dir at %s: %w", config.CIDIndexDir, err)
+		}
+	}
+	return &IPFSStorageService{
+		config:    config,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		hashToCid: make(map[common.Hash]string),
+	}, nil
+}
+
+func (i *IPFSStorageService) Put(ctx context.Context, data []byte, timeout uint64) error {
+	cid, err := i.addAndPinWithRetry(ctx, data)
+	if err != nil {
+		return fmt.Errorf("failed to pin data to IPFS: %w", err)
+	}
+
+	if i.config.Filecoin {
+		if err := i.placeDeal(ctx, cid, timeout); err != nil {
+			return fmt.Errorf("failed to place Filecoin storage deal for %s: %w", cid, err)
+		}
+	}
+
+	hash := common.BytesToHash(crypto.Keccak256(data))
+	if err := i.storeCid(hash, cid); err != nil {
+		return fmt.Errorf("failed to persist hash-to-CID index entry: %w", err)
+	}
+
+	return nil
+}
+
+// storeCid records hash's CID in the in-memory cache and, if CIDIndexDir is
+// configured, in a small on-disk KV (one file per hash) so the mapping
+// survives a restart instead of being lost along with the in-memory map.
+func (i *IPFSStorageService) storeCid(hash common.Hash, cid string) error {
+	i.mu.Lock()
+	i.hashToCid[hash] = cid
+	i.mu.Unlock()
+
+	if i.config.CIDIndexDir == "" {
+		return nil
+	}
+	return os.WriteFile(i.cidIndexPath(hash), []byte(cid), 0600)
+}
+
+func (i *IPFSStorageService) cidIndexPath(hash common.Hash) string {
+	return filepath.Join(i.config.CIDIndexDir, hash.Hex()[2:])
+}
+
+func (i *IPFSStorageService) GetByHash(ctx context.Context, hash []byte) ([]byte, error) {
+	var h common.Hash
+	copy(h[:], hash)
+
+	i.mu.RLock()
+	cid, ok := i.hashToCid[h]
+	i.mu.RUnlock()
+
+	if !ok && i.config.CIDIndexDir != "" {
+		contents, err := os.ReadFile(i.cidIndexPath(h))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to read hash-to-CID index entry: %w", err)
+			}
+		} else {
+			cid = string(contents)
+			ok = true
+			i.mu.Lock()
+			i.hashToCid[h] = cid
+			i.mu.Unlock()
+		}
+	}
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return i.catCID(ctx, cid)
+}
+
+func (i *IPFSStorageService) Sync(ctx context.Context) error {
+	return nil
+}
+
+func (i *IPFSStorageService) Close(ctx context.Context) error {
+	i.client.CloseIdleConnections()
+	return nil
+}
+
+func (i *IPFSStorageService) ExpirationPolicy(ctx context.Context) (ExpirationPolicy, error) {
+	if i.config.Filecoin {
+		return DiscardAfterDataTimeout, nil
+	}
+	return KeepForever, nil
+}
+
+func (i *IPFSStorageService) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.config.APIEndpoint+"/api/v0/id", nil)
+	if err != nil {
+		return err
+	}
+	i.authorize(req)
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("IPFS API unreachable at %s: %w", i.config.APIEndpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("IPFS API at %s returned status %d", i.config.APIEndpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+func (i *IPFSStorageService) String() string {
+	return fmt.Sprintf("IPFSStorageService(%s)", i.config.APIEndpoint)
+}
+
+func (i *IPFSStorageService) addAndPinWithRetry(ctx context.Context, data []byte) (string, error) {
+	var cid string
+	var err error
+	for attempt := 0; attempt <= i.config.RetryAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := i.config.RetryBackoff * time.Duration(attempt)
+			log.Warn("retrying IPFS pin", "attempt", attempt, "backoff", backoff, "err", err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		cid, err = i.addUnixFS(ctx, data)
+		if err != nil {
+			continue
+		}
+		if i.config.PinOnPut {
+			if err = i.pin(ctx, cid); err != nil {
+				continue
+			}
+		}
+		return cid, nil
+	}
+	return "", err
+}
+
+func (i *IPFSStorageService) addUnixFS(ctx context.Context, data []byte) (string, error) {
+	url := i.config.APIEndpoint + "/api/v0/add?pin=false&cid-version=1"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	i.authorize(req)
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d from IPFS add", resp.StatusCode)
+	}
+	// The Kubo RPC API returns a single JSON object with a "Hash" field
+	// containing the root CID for a single-file, non-directory add.
+	var result struct {
+		Hash string `json:"Hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.Hash, nil
+}
+
+func (i *IPFSStorageService) pin(ctx context.Context, cid string) error {
+	url := fmt.Sprintf("%s/api/v0/pin/add?arg=%s", i.config.APIEndpoint, cid)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	i.authorize(req)
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from IPFS pin/add", resp.StatusCode)
+	}
+	return nil
+}
+
+func (i *IPFSStorageService) catCID(ctx context.Context, cid string) ([]byte, error) {
+	url := fmt.Sprintf("%s/api/v0/cat?arg=%s", i.config.APIEndpoint, cid)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	i.authorize(req)
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from IPFS cat", resp.StatusCode)
+	}
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// placeDeal pushes the given CID into a Filecoin storage-deal client
+// (Boost/Lotus) over JSON-RPC, with a deal duration derived from the DAS
+// timeout for this message. It places one deal per miner in
+// deal-miner-address (comma-separated), up to ReplicationFactor of them, so
+// a ReplicationFactor greater than one actually buys redundant Filecoin
+// storage instead of being a no-op.
+func (i *IPFSStorageService) placeDeal(ctx context.Context, cid string, timeout uint64) error {
+	if i.config.DealClientURL == "" {
+		return fmt.Errorf("filecoin mode enabled but deal-client-url is not configured")
+	}
+	miners := dealMiners(i.config.DealMinerAddress, i.config.ReplicationFactor)
+	if len(miners) == 0 {
+		return fmt.Errorf("filecoin mode enabled but deal-miner-address is not configured")
+	}
+	for _, miner := range miners {
+		if err := i.placeDealWithMiner(ctx, cid, miner, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dealMiners splits a comma-separated miner address list and truncates it to
+// at most replicationFactor entries (or all of them, if fewer are listed).
+func dealMiners(dealMinerAddress string, replicationFactor int) []string {
+	var miners []string
+	for _, miner := range strings.Split(dealMinerAddress, ",") {
+		miner = strings.TrimSpace(miner)
+		if miner != "" {
+			miners = append(miners, miner)
+		}
+	}
+	if replicationFactor > 0 && len(miners) > replicationFactor {
+		miners = miners[:replicationFactor]
+	}
+	return miners
+}
+
+func (i *IPFSStorageService) placeDealWithMiner(ctx context.Context, cid string, miner string, timeout uint64) error {
+	params := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "Filecoin.ClientStartDeal",
+		"id":      1,
+		"params": []interface{}{map[string]interface{}{
+			"Data": map[string]string{
+				"Root": cid,
+			},
+			"Miner":             miner,
+			"EpochPrice":        "0",
+			"MinBlocksDuration": dealDurationForTimeout(timeout),
+			"FastRetrieval":     true,
+		}},
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.config.DealClientURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if i.config.DealClientToken != "" {
+		req.Header.Set("Authorization", "Bearer "+i.config.DealClientToken)
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from storage-deal client for miner %s", resp.StatusCode, miner)
+	}
+	return nil
+}
+
+func (i *IPFSStorageService) authorize(req *http.Request) {
+	if i.config.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+i.config.APIToken)
+	}
+}
+
+// dealDurationForTimeout converts a DAS expiration timeout into an
+// approximate Filecoin epoch-count deal duration (Filecoin epochs are ~30s).
+func dealDurationForTimeout(timeout uint64) uint64 {
+	const secondsPerEpoch = 30
+	now := uint64(time.Now().Unix())
+	if timeout <= now {
+		return 0
+	}
+	return (timeout - now) / secondsPerEpoch
+}
@@ -0,0 +1,117 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestDealMinersTruncatesToReplicationFactor(t *testing.T) {
+	miners := dealMiners("f01, f02,f03", 2)
+	if len(miners) != 2 || miners[0] != "f01" || miners[1] != "f02" {
+		t.Fatalf("expected [f01 f02], got %v", miners)
+	}
+}
+
+func TestDealMinersReplicationFactorLargerThanListIsNotTruncated(t *testing.T) {
+	miners := dealMiners("f01,f02", 5)
+	if len(miners) != 2 {
+		t.Fatalf("expected both miners when replicationFactor exceeds the list, got %v", miners)
+	}
+}
+
+func TestDealMinersZeroReplicationFactorIsUnbounded(t *testing.T) {
+	miners := dealMiners("f01,f02,f03", 0)
+	if len(miners) != 3 {
+		t.Fatalf("expected no truncation for a zero replicationFactor, got %v", miners)
+	}
+}
+
+func TestDealMinersEmptyAddressIsEmpty(t *testing.T) {
+	if miners := dealMiners("", 3); len(miners) != 0 {
+		t.Fatalf("expected no miners for an empty deal-miner-address, got %v", miners)
+	}
+}
+
+func TestDealDurationForTimeout(t *testing.T) {
+	now := uint64(time.Now().Unix())
+
+	if got := dealDurationForTimeout(now - 1000); got != 0 {
+		t.Fatalf("a past timeout should yield a zero duration, got %d", got)
+	}
+
+	const secondsPerEpoch = 30
+	future := now + 300
+	got := dealDurationForTimeout(future)
+	want := (future - now) / secondsPerEpoch
+	// Allow the elapsed wall-clock time between the two now-captures to
+	// shift the expected value by at most one epoch.
+	if got < want-1 || got > want+1 {
+		t.Fatalf("expected approximately %d epochs, got %d", want, got)
+	}
+}
+
+// TestIPFSGetByHashFallsBackToDiskIndex confirms GetByHash recovers a CID
+// mapping from CIDIndexDir on a cache miss, rather than returning ErrNotFound
+// just because the in-memory hashToCid map (lost on every restart) doesn't
+// have it anymore.
+func TestIPFSGetByHashFallsBackToDiskIndex(t *testing.T) {
+	const wantData = "hello from ipfs"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(wantData))
+	}))
+	defer server.Close()
+
+	svc := &IPFSStorageService{
+		config: IPFSConfig{
+			Enable:      true,
+			APIEndpoint: server.URL,
+			CIDIndexDir: t.TempDir(),
+		},
+		client:    &http.Client{Timeout: 5 * time.Second},
+		hashToCid: make(map[common.Hash]string),
+	}
+
+	var hash common.Hash
+	copy(hash[:], []byte("some-keccak-hash-32-bytes-long!"))
+
+	if err := svc.storeCid(hash, "bafyTestCid"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a restart: the in-memory cache is gone, but the on-disk
+	// index should still resolve the hash to its CID.
+	svc.hashToCid = make(map[common.Hash]string)
+
+	got, err := svc.GetByHash(context.Background(), hash[:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(got) != wantData {
+		t.Fatalf("expected %q, got %q", wantData, got)
+	}
+
+	if _, err := svc.GetByHash(context.Background(), common.Hash{}.Bytes()); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound for a hash with no index entry, got %v", err)
+	}
+}
+
+func TestIPFSCidIndexPath(t *testing.T) {
+	svc := &IPFSStorageService{config: IPFSConfig{CIDIndexDir: "/tmp/cid-index"}}
+	var hash common.Hash
+	copy(hash[:], []byte("some-keccak-hash-32-bytes-long!"))
+
+	got := svc.cidIndexPath(hash)
+	want := filepath.Join("/tmp/cid-index", hash.Hex()[2:])
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/offchainlabs/nitro/blsSignatures"
+)
+
+// DefaultKeyRotationGracePeriod is how long a retired signing key is
+// considered to still have been valid for, if StorageConfig doesn't
+// override it.
+const DefaultKeyRotationGracePeriod = 7 * 24 * time.Hour
+
+const defaultKeyRotationJournalName = "key-rotation.json"
+
+// keyRotationRecord is one entry of the on-disk rotation journal: a keyset
+// this DAS has advertised, when it became the active keyset, and when (if
+// ever) it was retired.
+type keyRotationRecord struct {
+	KeysetHash  [32]byte   `json:"keysetHash"`
+	ActivatedAt time.Time  `json:"activatedAt"`
+	RetiredAt   *time.Time `json:"retiredAt,omitempty"`
+}
+
+// keyRotationJournal is a small append-style log of every keyset a DAS has
+// activated and retired, persisted to disk so a restart doesn't lose
+// rotation history.
+type keyRotationJournal struct {
+	path string
+
+	mu      sync.Mutex
+	Records []keyRotationRecord `json:"records"`
+}
+
+func journalPathFor(config StorageConfig) string {
+	if config.KeyRotationJournal != "" {
+		return config.KeyRotationJournal
+	}
+	return filepath.Join(config.KeyDir, defaultKeyRotationJournalName)
+}
+
+// loadOrCreateKeyRotationJournal loads the rotation journal at path, or
+// creates a new one recording activeKsHash as the first active keyset if no
+// journal exists yet.
+func loadOrCreateKeyRotationJournal(path string, activeKsHash [32]byte) (*keyRotationJournal, error) {
+	journal := &keyRotationJournal{path: path}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read key rotation journal at %s: %w", path, err)
+		}
+		journal.Records = []keyRotationRecord{{
+			KeysetHash:  activeKsHash,
+			ActivatedAt: time.Now(),
+		}}
+		return journal, journal.persist()
+	}
+
+	if err := json.Unmarshal(contents, journal); err != nil {
+		return nil, fmt.Errorf("invalid key rotation journal at %s: %w", path, err)
+	}
+	return journal, nil
+}
+
+func (j *keyRotationJournal) persist() error {
+	contents, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	if j.path == "" {
+		return nil
+	}
+	return os.WriteFile(j.path, contents, 0644)
+}
+
+func (j *keyRotationJournal) recordRotation(retiredKsHash, activeKsHash [32]byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	for idx := range j.Records {
+		if j.Records[idx].KeysetHash == retiredKsHash && j.Records[idx].RetiredAt == nil {
+			j.Records[idx].RetiredAt = &now
+		}
+	}
+	j.Records = append(j.Records, keyRotationRecord{
+		KeysetHash:  activeKsHash,
+		ActivatedAt: now,
+	})
+
+	return j.persist()
+}
+
+// RotateKey installs newPriv as the DAS's active signing key: it builds and
+// persists a new keyset advertising newPriv's public key, starts signing new
+// Store calls with it, retires the previous key (whose dasPubKeyHash
+// checkDomainSeparator keeps accepting for config.KeyRotationGracePeriod;
+// its keyset stays servable from KeysetFromHash with no time limit), and
+// records the rotation in the on-disk journal.
+func (d *DAS) RotateKey(ctx context.Context, newPriv *blsSignatures.PrivateKey) (newKsHash [32]byte, err error) {
+	newPubKey, err := blsSignatures.PublicKeyFromPrivateKey(*newPriv)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	pubKeys := []blsSignatures.PublicKey{newPubKey}
+	for _, extra := range d.config.ExtraPubKeys {
+		extraPubKey, err := DecodeBase64BLSPublicKey([]byte(extra))
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("'extra-pub-keys' entry was invalid: %w", err)
+		}
+		pubKeys = append(pubKeys, *extraPubKey)
+	}
+	assumedHonest := d.config.AssumedHonest
+	if assumedHonest == 0 {
+		assumedHonest = 1
+	}
+
+	newKsHash, newKsBytes, err := buildAndStoreKeyset(ctx, d.storageService, assumedHonest, pubKeys)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	gracePeriod := d.config.KeyRotationGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = DefaultKeyRotationGracePeriod
+	}
+
+	d.rotationMutex.Lock()
+	retiringPrivKey := d.privKey
+	retiredKsHash := d.keysetHash
+	d.retiringPrivKey = retiringPrivKey
+	d.retiringUntil = time.Now().Add(gracePeriod)
+	d.privKey = newPriv
+	d.keysetHash = newKsHash
+	d.keysetBytes = newKsBytes
+	d.rotationMutex.Unlock()
+
+	if err := d.journal.recordRotation(retiredKsHash, newKsHash); err != nil {
+		return [32]byte{}, fmt.Errorf("key rotation succeeded but failed to persist journal: %w", err)
+	}
+
+	return newKsHash, nil
+}
+
+// RotateKeyFromDir reads a replacement BLS key from newKeyDir (in the same
+// plaintext or keystore v3 layout NewDASWithSeqInboxCaller itself reads from
+// key-dir, selected by newKeystoreFormat) and rotates to it via RotateKey.
+// It takes a directory and a passphrase rather than an in-memory key, so a
+// command line can call it with only the flags it has on hand. No CLI
+// subcommand calls it: RotateKeyFromDir is a library entry point only.
+func RotateKeyFromDir(ctx context.Context, d *DAS, newKeyDir string, newKeystoreFormat string, passphrase string) (newKsHash [32]byte, err error) {
+	var newPriv *blsSignatures.PrivateKey
+	if newKeystoreFormat == KeystoreFormatV3 {
+		keystoreJSON, err := os.ReadFile(filepath.Join(newKeyDir, DefaultPrivKeyFilename))
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("failed to read replacement keystore at %s: %w", newKeyDir, err)
+		}
+		newPriv, err = LoadEncryptedBLSKey(keystoreJSON, passphrase)
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("failed to unlock replacement keystore at %s: %w", newKeyDir, err)
+		}
+	} else {
+		_, newPriv, err = ReadKeysFromFile(newKeyDir)
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("failed to read replacement BLS keypair at %s: %w", newKeyDir, err)
+		}
+	}
+
+	return d.RotateKey(ctx, newPriv)
+}
@@ -8,7 +8,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -54,8 +58,25 @@ type StorageConfig struct {
 	S3Config            genericconf.S3Config `koanf:"s3"`
 	RedisConfig         RedisConfig          `koanf:"redis"`
 	BigCacheConfig      BigCacheConfig       `koanf:"big-cache"`
+	IPFSConfig          IPFSConfig           `koanf:"ipfs"`
 	AllowGenerateKeys   bool                 `koanf:"allow-generate-keys"`
 	StorageType         string               `koanf:"storage-type"`
+	StorageTiers        []string             `koanf:"storage-tiers"`
+
+	KeystoreFormat  string `koanf:"keystore-format"`
+	Passphrase      string `koanf:"passphrase"`
+	PassphraseFile  string `koanf:"passphrase-file"`
+	KeystoreScryptN int    `koanf:"keystore-scrypt-n"`
+	KeystoreScryptR int    `koanf:"keystore-scrypt-r"`
+	KeystoreScryptP int    `koanf:"keystore-scrypt-p"`
+
+	ExtraPubKeys           []string      `koanf:"extra-pub-keys"`
+	AssumedHonest          int           `koanf:"assumed-honest"`
+	KeyRotationGracePeriod time.Duration `koanf:"key-rotation-grace-period"`
+	KeyRotationJournal     string        `koanf:"key-rotation-journal"`
+
+	MaxTimeoutSkew     time.Duration `koanf:"max-timeout-skew"`
+	SignerNonceJournal string        `koanf:"signer-nonce-journal"`
 }
 
 type LocalConfig struct {
@@ -74,20 +95,45 @@ func StorageConfigAddOptions(prefix string, f *flag.FlagSet) {
 	f.String(prefix+".key-dir", "", fmt.Sprintf("The directory to read the bls keypair ('%s' and '%s') from", DefaultPubKeyFilename, DefaultPrivKeyFilename))
 	f.String(prefix+".priv-key", "", "The base64 BLS private key to use for signing DAS certificates")
 	f.Bool(prefix+".discard-after-timeout", false, "Discard data after timeout in DAS")
+	f.StringSlice(prefix+".storage-tiers", nil, "Ordered list of storage tiers to compose as a fallback pipeline (e.g. 'big-cache,redis,s3'); if empty, 'storage-type' selects a single backend")
 	LocalConfigAddOptions(prefix+".local", f)
 	genericconf.S3ConfigAddOptions(prefix+".s3", f)
 	RedisConfigAddOptions(prefix+".redis", f)
 	BigCacheConfigAddOptions(prefix+".big-cache", f)
+	IPFSConfigAddOptions(prefix+".ipfs", f)
 	f.Bool(prefix+".allow-generate-keys", false, "Allow the local disk DAS to generate its own keys in key-dir if they don't already exist")
+	f.String(prefix+".keystore-format", KeystoreFormatPlaintext, fmt.Sprintf("The format the BLS keypair in key-dir is stored in, '%s' or '%s'", KeystoreFormatPlaintext, KeystoreFormatV3))
+	f.String(prefix+".passphrase", "", "The passphrase to decrypt the BLS keystore, if keystore-format is set to 'v3'")
+	f.String(prefix+".passphrase-file", "", "A file (or systemd credential name, if run under LoadCredential) to read the keystore passphrase from, taking precedence over 'passphrase'")
+	f.Int(prefix+".keystore-scrypt-n", DefaultKeystoreScryptN, "The scrypt N parameter to use when encrypting a newly generated keystore")
+	f.Int(prefix+".keystore-scrypt-r", DefaultKeystoreScryptR, "The scrypt r parameter to use when encrypting a newly generated keystore")
+	f.Int(prefix+".keystore-scrypt-p", DefaultKeystoreScryptP, "The scrypt p parameter to use when encrypting a newly generated keystore")
+	f.StringSlice(prefix+".extra-pub-keys", nil, "Additional base64 BLS public keys (e.g. a retiring key mid-rotation) to include in the advertised keyset alongside this DAS's own signing key")
+	f.Int(prefix+".assumed-honest", 1, "The AssumedHonest threshold to advertise in this DAS's keyset")
+	f.Duration(prefix+".key-rotation-grace-period", DefaultKeyRotationGracePeriod, "How long a retired signing key remains valid for verification after RotateKey is called")
+	f.String(prefix+".key-rotation-journal", "", "Path to the journal file tracking keyset activation/retirement history across restarts; defaults to 'key-rotation.json' inside key-dir")
+	f.Duration(prefix+".max-timeout-skew", DefaultMaxTimeoutSkew, "Reject replay-protected Store requests whose timeout is further than this from the current wall clock time")
+	f.String(prefix+".signer-nonce-journal", "", "Path to the file tracking each signer's highest-seen replay-protection nonce across restarts; defaults to 'signer-nonces.json' inside key-dir")
 }
 
 type DAS struct {
 	config         StorageConfig
-	privKey        *blsSignatures.PrivateKey
-	keysetHash     [32]byte
-	keysetBytes    []byte
 	storageService StorageService
 	bpVerifier     *BatchPosterVerifier
+
+	// rotationMutex guards privKey, keysetHash, keysetBytes, and the
+	// retiring-key fields below, all of which RotateKey updates atomically.
+	rotationMutex   sync.RWMutex
+	privKey         *blsSignatures.PrivateKey
+	keysetHash      [32]byte
+	keysetBytes     []byte
+	retiringPrivKey *blsSignatures.PrivateKey
+	retiringUntil   time.Time
+	journal         *keyRotationJournal
+
+	// nonceMutex serializes the load-check-advance-save sequence against
+	// the persisted per-signer nonce high-water marks.
+	nonceMutex sync.Mutex
 }
 
 func NewDAS(ctx context.Context, config DataAvailabilityConfig) (*DAS, error) {
@@ -139,6 +185,31 @@ func NewDASWithSeqInboxCaller(
 		if err != nil {
 			return nil, fmt.Errorf("'priv-key' was invalid: %w", err)
 		}
+	} else if config.KeystoreFormat == KeystoreFormatV3 {
+		passphrase, err := readPassphrase(config)
+		if err != nil {
+			return nil, err
+		}
+		keystoreJSON, err := os.ReadFile(filepath.Join(config.KeyDir, DefaultPrivKeyFilename))
+		if err != nil {
+			if os.IsNotExist(err) {
+				if config.AllowGenerateKeys {
+					_, privKey, err = GenerateAndStoreEncryptedKeys(config.KeyDir, passphrase, config.KeystoreScryptN, config.KeystoreScryptR, config.KeystoreScryptP)
+					if err != nil {
+						return nil, err
+					}
+				} else {
+					return nil, fmt.Errorf("Required BLS keystore did not exist at %s", config.KeyDir)
+				}
+			} else {
+				return nil, err
+			}
+		} else {
+			privKey, err = LoadEncryptedBLSKey(keystoreJSON, passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unlock BLS keystore at %s: %w", config.KeyDir, err)
+			}
+		}
 	} else {
 		_, privKey, err = ReadKeysFromFile(config.KeyDir)
 		if err != nil {
@@ -161,38 +232,91 @@ func NewDASWithSeqInboxCaller(
 	if err != nil {
 		return nil, err
 	}
-
-	keyset := &arbstate.DataAvailabilityKeyset{
-		AssumedHonest: 1,
-		PubKeys:       []blsSignatures.PublicKey{publicKey},
+	pubKeys := []blsSignatures.PublicKey{publicKey}
+	for _, extra := range config.ExtraPubKeys {
+		extraPubKey, err := DecodeBase64BLSPublicKey([]byte(extra))
+		if err != nil {
+			return nil, fmt.Errorf("'extra-pub-keys' entry was invalid: %w", err)
+		}
+		pubKeys = append(pubKeys, *extraPubKey)
 	}
-	ksBuf := bytes.NewBuffer([]byte{})
-	if err := keyset.Serialize(ksBuf); err != nil {
+	assumedHonest := config.AssumedHonest
+	if assumedHonest == 0 {
+		assumedHonest = 1
+	}
+
+	ksHash, ksBytes, err := buildAndStoreKeyset(ctx, storageService, assumedHonest, pubKeys)
+	if err != nil {
 		return nil, err
 	}
-	ksHashBuf, err := keyset.Hash()
+
+	journal, err := loadOrCreateKeyRotationJournal(journalPathFor(config), ksHash)
 	if err != nil {
 		return nil, err
 	}
-	var ksHash [32]byte
-	copy(ksHash[:], ksHashBuf)
 
 	var bpVerifier *BatchPosterVerifier
 	if seqInboxCaller != nil {
 		bpVerifier = NewBatchPosterVerifier(seqInboxCaller)
 	}
 
+	gracePeriod := config.KeyRotationGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = DefaultKeyRotationGracePeriod
+	}
+
 	return &DAS{
 		config:         config,
 		privKey:        privKey,
 		keysetHash:     ksHash,
-		keysetBytes:    ksBuf.Bytes(),
+		keysetBytes:    ksBytes,
 		storageService: storageService,
 		bpVerifier:     bpVerifier,
+		journal:        journal,
 	}, nil
 }
 
+// buildAndStoreKeyset serializes a DataAvailabilityKeyset for pubKeys,
+// computes its hash, and persists the serialized bytes into storageService
+// under that hash, so KeysetFromHash can serve it back even after the DAS
+// later rotates to a different active keyset.
+func buildAndStoreKeyset(ctx context.Context, storageService StorageService, assumedHonest int, pubKeys []blsSignatures.PublicKey) ([32]byte, []byte, error) {
+	keyset := &arbstate.DataAvailabilityKeyset{
+		AssumedHonest: uint64(assumedHonest),
+		PubKeys:       pubKeys,
+	}
+	ksBuf := bytes.NewBuffer([]byte{})
+	if err := keyset.Serialize(ksBuf); err != nil {
+		return [32]byte{}, nil, err
+	}
+	ksHashBuf, err := keyset.Hash()
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	var ksHash [32]byte
+	copy(ksHash[:], ksHashBuf)
+
+	ksBytes := ksBuf.Bytes()
+	if err := storageService.Put(ctx, ksBytes, math.MaxUint64); err != nil {
+		return [32]byte{}, nil, fmt.Errorf("failed to persist keyset: %w", err)
+	}
+
+	return ksHash, ksBytes, nil
+}
+
+// NewStorageServiceFromStorageConfig builds the StorageService the DAS
+// stores and serves messages through. If config.StorageTiers is set, it
+// composes the named tiers into a fallback pipeline via
+// buildStorageTierPipeline; otherwise it falls back to the single backend
+// named by config.StorageType for backwards compatibility.
 func NewStorageServiceFromStorageConfig(ctx context.Context, config StorageConfig) (StorageService, error) {
+	if len(config.StorageTiers) > 0 {
+		return buildStorageTierPipeline(ctx, config)
+	}
+	return legacyStorageServiceFromConfig(ctx, config)
+}
+
+func legacyStorageServiceFromConfig(ctx context.Context, config StorageConfig) (StorageService, error) {
 	var storageService StorageService
 	var err error
 	switch config.StorageType {
@@ -234,49 +358,100 @@ func NewStorageServiceFromStorageConfig(ctx context.Context, config StorageConfi
 		if err != nil {
 			return nil, err
 		}
+	case "ipfs", "filecoin":
+		ipfsConfig := config.IPFSConfig
+		ipfsConfig.Enable = true
+		ipfsConfig.Filecoin = config.StorageType == "filecoin"
+		storageService, err = NewIPFSStorageService(ipfsConfig)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, errors.New("Storage service type not recognized: " + config.StorageType)
 	}
 	return storageService, nil
 }
 
+// Store is the DAS's single Store entry point. sig's wire format is
+// self-describing (see decodeStoreRequestSig): a plain 65-byte ECDSA
+// signature is treated as StoreRequestVersionLegacy and verified exactly as
+// this method always has, while a StoreRequestVersionReplayProtected-encoded
+// sig additionally carries a nonce and a dasPubKeyHash domain separator that
+// Store enforces here, so there is no separate, unenforced code path a
+// caller could end up on.
 func (d *DAS) Store(ctx context.Context, message []byte, timeout uint64, sig []byte) (c *arbstate.DataAvailabilityCertificate, err error) {
+	version, nonce, dasPubKeyHash, actualSig, err := decodeStoreRequestSig(sig)
+	if err != nil {
+		return nil, err
+	}
+
 	if d.bpVerifier != nil {
-		actualSigner, err := DasRecoverSigner(message, timeout, sig)
+		var signer common.Address
+		if version == StoreRequestVersionReplayProtected {
+			if err := d.checkDomainSeparator(dasPubKeyHash); err != nil {
+				return nil, err
+			}
+			maxSkew := d.config.MaxTimeoutSkew
+			if maxSkew == 0 {
+				maxSkew = DefaultMaxTimeoutSkew
+			}
+			if err := checkTimeoutSkew(timeout, maxSkew); err != nil {
+				return nil, err
+			}
+			signer, err = DasRecoverSignerV2(message, timeout, nonce, dasPubKeyHash, actualSig)
+		} else {
+			signer, err = DasRecoverSigner(message, timeout, actualSig)
+		}
 		if err != nil {
 			return nil, err
 		}
-		isBatchPoster, err := d.bpVerifier.IsBatchPoster(ctx, actualSigner)
+		isBatchPoster, err := d.bpVerifier.IsBatchPoster(ctx, signer)
 		if err != nil {
 			return nil, err
 		}
 		if !isBatchPoster {
 			return nil, errors.New("store request not properly signed")
 		}
+		if version == StoreRequestVersionReplayProtected {
+			if err := d.checkAndAdvanceNonce(ctx, signer, nonce); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	c = &arbstate.DataAvailabilityCertificate{}
+	return d.storeVerified(ctx, message, timeout)
+}
+
+// storeVerified signs and persists message/timeout once Store has already
+// authenticated the request.
+func (d *DAS) storeVerified(ctx context.Context, message []byte, timeout uint64) (*arbstate.DataAvailabilityCertificate, error) {
+	c := &arbstate.DataAvailabilityCertificate{}
 	copy(c.DataHash[:], crypto.Keccak256(message))
 
 	c.Timeout = timeout
 	c.SignersMask = 1 // The aggregator will override this if we're part of a committee.
 
 	fields := c.SerializeSignableFields()
-	c.Sig, err = blsSignatures.SignMessage(*d.privKey, fields)
+
+	d.rotationMutex.RLock()
+	signingKey := d.privKey
+	ksHash := d.keysetHash
+	d.rotationMutex.RUnlock()
+
+	sig, err := blsSignatures.SignMessage(*signingKey, fields)
 	if err != nil {
 		return nil, err
 	}
+	c.Sig = sig
 
-	err = d.storageService.Put(ctx, message, timeout)
-	if err != nil {
+	if err := d.storageService.Put(ctx, message, timeout); err != nil {
 		return nil, err
 	}
-	err = d.storageService.Sync(ctx)
-	if err != nil {
+	if err := d.storageService.Sync(ctx); err != nil {
 		return nil, err
 	}
 
-	c.KeysetHash = d.keysetHash
+	c.KeysetHash = ksHash
 
 	return c, nil
 }
@@ -286,9 +461,16 @@ func (d *DAS) GetByHash(ctx context.Context, hash []byte) ([]byte, error) {
 }
 
 func (d *DAS) KeysetFromHash(ctx context.Context, ksHash []byte) ([]byte, error) {
-	if bytes.Equal(ksHash, d.keysetHash[:]) {
-		return d.keysetBytes, nil
+	d.rotationMutex.RLock()
+	currentHash, currentBytes := d.keysetHash, d.keysetBytes
+	d.rotationMutex.RUnlock()
+
+	if bytes.Equal(ksHash, currentHash[:]) {
+		return currentBytes, nil
 	}
+	// Every keyset this DAS has ever advertised, including retired ones, was
+	// persisted into storageService under its own hash when it was built, so
+	// this also serves historical certs from before the most recent rotation.
 	contents, err := d.GetByHash(ctx, ksHash)
 	if err == nil {
 		return contents, nil
@@ -297,6 +479,8 @@ func (d *DAS) KeysetFromHash(ctx context.Context, ksHash []byte) ([]byte, error)
 }
 
 func (d *DAS) CurrentKeysetBytes(ctx context.Context) ([]byte, error) {
+	d.rotationMutex.RLock()
+	defer d.rotationMutex.RUnlock()
 	return d.keysetBytes, nil
 }
 
@@ -0,0 +1,252 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/offchainlabs/nitro/blsSignatures"
+)
+
+const defaultSignerNonceJournalName = "signer-nonces.json"
+
+// noncePathFor resolves where the per-signer nonce high-water marks are
+// persisted, mirroring journalPathFor's key-rotation-journal default.
+func noncePathFor(config StorageConfig) string {
+	if config.SignerNonceJournal != "" {
+		return config.SignerNonceJournal
+	}
+	return filepath.Join(config.KeyDir, defaultSignerNonceJournalName)
+}
+
+// StoreRequestVersion distinguishes the wire format Store's sig argument is
+// encoded in, so a DAS can keep accepting StoreRequestVersionLegacy
+// requests from clients that haven't rolled out replay protection yet. See
+// decodeStoreRequestSig.
+type StoreRequestVersion uint8
+
+const (
+	// StoreRequestVersionLegacy signs only (message, timeout), as the
+	// original DAS.Store always has. It carries no nonce or domain
+	// separator, so it remains replayable against every DAS in the
+	// committee and isn't rejected for staleness.
+	StoreRequestVersionLegacy StoreRequestVersion = 0
+	// StoreRequestVersionReplayProtected additionally signs a
+	// monotonically increasing per-signer nonce and the target DAS's
+	// public key hash as a domain separator.
+	StoreRequestVersionReplayProtected StoreRequestVersion = 1
+)
+
+// ecdsaSigLen is the length of the plain recoverable ECDSA signature
+// (R || S || V) that Store has always accepted as sig.
+const ecdsaSigLen = 65
+
+// replayProtectedSigLen is the length of a StoreRequestVersionReplayProtected
+// sig: a one-byte version prefix, an 8-byte big-endian nonce, a 32-byte
+// dasPubKeyHash, and the underlying ecdsaSigLen-byte signature.
+const replayProtectedSigLen = 1 + 8 + 32 + ecdsaSigLen
+
+// DefaultMaxTimeoutSkew bounds how far a Store request's timeout may be from
+// wall clock time before it's rejected, so a signature can't be used to
+// backfill an arbitrary historical or far-future expiration.
+const DefaultMaxTimeoutSkew = 64 * 24 * time.Hour
+
+var (
+	ErrNonceTooLow    = errors.New("store request nonce has already been used")
+	ErrTimeoutSkewed  = errors.New("store request timeout is too far from current time")
+	ErrWrongDAS       = errors.New("store request was signed for a different DAS")
+	ErrUnknownSigWire = errors.New("store request sig has an unrecognized wire format")
+)
+
+// replayProtectedSignedFields returns the Keccak256 hash a
+// StoreRequestVersionReplayProtected request's signature covers, taken over
+// the message, the timeout, the nonce, and the destination DAS's public key
+// hash, each encoded so that a signature computed for one DAS or one nonce
+// can't be replayed against another.
+func replayProtectedSignedFields(message []byte, timeout uint64, nonce uint64, dasPubKeyHash [32]byte) []byte {
+	buf := make([]byte, 0, len(message)+8+8+32)
+	buf = append(buf, message...)
+	var timeoutBytes [8]byte
+	binary.BigEndian.PutUint64(timeoutBytes[:], timeout)
+	buf = append(buf, timeoutBytes[:]...)
+	var nonceBytes [8]byte
+	binary.BigEndian.PutUint64(nonceBytes[:], nonce)
+	buf = append(buf, nonceBytes[:]...)
+	buf = append(buf, dasPubKeyHash[:]...)
+	return crypto.Keccak256(buf)
+}
+
+// DasSignRequest is the client-side counterpart of Store: batch posters
+// should use it (instead of signing (message, timeout) directly) so their
+// requests carry replay protection once a DAS has rolled out
+// StoreRequestVersionReplayProtected. The returned bytes are ready to pass
+// as Store's sig argument; Store recognizes the encoding via
+// decodeStoreRequestSig and enforces the nonce/timeout/domain-separator
+// checks against it directly, so there's no separate call an operator needs
+// to remember to make.
+func DasSignRequest(key *ecdsa.PrivateKey, message []byte, timeout uint64, nonce uint64, dasPubKeyHash [32]byte) ([]byte, error) {
+	fields := replayProtectedSignedFields(message, timeout, nonce, dasPubKeyHash)
+	actualSig, err := crypto.Sign(fields, key)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := make([]byte, 0, replayProtectedSigLen)
+	sig = append(sig, byte(StoreRequestVersionReplayProtected))
+	var nonceBytes [8]byte
+	binary.BigEndian.PutUint64(nonceBytes[:], nonce)
+	sig = append(sig, nonceBytes[:]...)
+	sig = append(sig, dasPubKeyHash[:]...)
+	sig = append(sig, actualSig...)
+	return sig, nil
+}
+
+// decodeStoreRequestSig recognizes Store's sig wire format: a bare
+// ecdsaSigLen-byte signature is StoreRequestVersionLegacy, and a
+// replayProtectedSigLen-byte, version-prefixed blob is
+// StoreRequestVersionReplayProtected. This keeps Store itself as the single
+// entry point that enforces replay protection, while old clients that only
+// ever produced a bare signature keep working unchanged during rollout.
+func decodeStoreRequestSig(sig []byte) (version StoreRequestVersion, nonce uint64, dasPubKeyHash [32]byte, actualSig []byte, err error) {
+	switch {
+	case len(sig) == ecdsaSigLen:
+		return StoreRequestVersionLegacy, 0, [32]byte{}, sig, nil
+	case len(sig) == replayProtectedSigLen && sig[0] == byte(StoreRequestVersionReplayProtected):
+		nonce = binary.BigEndian.Uint64(sig[1:9])
+		copy(dasPubKeyHash[:], sig[9:41])
+		actualSig = sig[41:]
+		return StoreRequestVersionReplayProtected, nonce, dasPubKeyHash, actualSig, nil
+	default:
+		return 0, 0, [32]byte{}, nil, fmt.Errorf("%w: length %d", ErrUnknownSigWire, len(sig))
+	}
+}
+
+// DasRecoverSignerV2 recovers the address that produced sig over
+// (message, timeout, nonce, dasPubKeyHash), mirroring DasRecoverSigner but
+// for the replay-protected wire format.
+func DasRecoverSignerV2(message []byte, timeout uint64, nonce uint64, dasPubKeyHash [32]byte, sig []byte) (common.Address, error) {
+	fields := replayProtectedSignedFields(message, timeout, nonce, dasPubKeyHash)
+	pubkey, err := crypto.SigToPub(fields, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+func blsPubKeyHash(privKey *blsSignatures.PrivateKey) ([32]byte, error) {
+	pubKey, err := blsSignatures.PublicKeyFromPrivateKey(*privKey)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	var hash [32]byte
+	copy(hash[:], crypto.Keccak256(blsSignatures.PublicKeyToBytes(pubKey)))
+	return hash, nil
+}
+
+// checkDomainSeparator accepts candidate as this DAS's dasPubKeyHash domain
+// separator if it matches the active signing key, or (during the
+// KeyRotationGracePeriod following a RotateKey call) the just-retired key, so
+// batch posters that haven't picked up the new dasPubKeyHash yet aren't
+// locked out immediately at rotation.
+func (d *DAS) checkDomainSeparator(candidate [32]byte) error {
+	d.rotationMutex.RLock()
+	activeKey := d.privKey
+	retiringKey := d.retiringPrivKey
+	retiringUntil := d.retiringUntil
+	d.rotationMutex.RUnlock()
+
+	activeHash, err := blsPubKeyHash(activeKey)
+	if err != nil {
+		return err
+	}
+	if candidate == activeHash {
+		return nil
+	}
+
+	if retiringKey != nil && time.Now().Before(retiringUntil) {
+		retiringHash, err := blsPubKeyHash(retiringKey)
+		if err != nil {
+			return err
+		}
+		if candidate == retiringHash {
+			return nil
+		}
+	}
+
+	return ErrWrongDAS
+}
+
+// loadSignerNonces returns the persisted map of highest-seen nonce per
+// signer, or an empty map if none has been persisted yet. This state is not
+// stored through d.storageService: StorageService is content-addressed (its
+// Put derives the retrieval key from Keccak256(data), as buildAndStoreKeyset
+// and IPFSStorageService.Put both rely on), so there is no fixed key a
+// changing nonce map could be read back under. It is persisted to its own
+// file instead, the way key_rotation.go's journal is.
+func (d *DAS) loadSignerNonces() (map[common.Address]uint64, error) {
+	contents, err := os.ReadFile(noncePathFor(d.config))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return make(map[common.Address]uint64), nil
+		}
+		return nil, fmt.Errorf("failed to read signer nonce journal: %w", err)
+	}
+	nonces := make(map[common.Address]uint64)
+	if err := json.Unmarshal(contents, &nonces); err != nil {
+		return nil, fmt.Errorf("invalid signer nonce journal: %w", err)
+	}
+	return nonces, nil
+}
+
+func (d *DAS) saveSignerNonces(nonces map[common.Address]uint64) error {
+	contents, err := json.MarshalIndent(nonces, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := noncePathFor(d.config)
+	if path == "" {
+		return nil
+	}
+	return os.WriteFile(path, contents, 0600)
+}
+
+// checkAndAdvanceNonce rejects a replay (nonce <= the highest one already
+// seen from signer) and otherwise persists the new high-water mark before
+// the Store request is allowed to proceed.
+func (d *DAS) checkAndAdvanceNonce(ctx context.Context, signer common.Address, nonce uint64) error {
+	d.nonceMutex.Lock()
+	defer d.nonceMutex.Unlock()
+
+	nonces, err := d.loadSignerNonces()
+	if err != nil {
+		return err
+	}
+	if nonce <= nonces[signer] {
+		return ErrNonceTooLow
+	}
+	nonces[signer] = nonce
+	return d.saveSignerNonces(nonces)
+}
+
+// checkTimeoutSkew rejects a timeout that's further than maxSkew from the
+// current wall clock time, in either direction.
+func checkTimeoutSkew(timeout uint64, maxSkew time.Duration) error {
+	now := time.Now()
+	requestedTimeout := time.Unix(int64(timeout), 0)
+	if requestedTimeout.Before(now.Add(-maxSkew)) || requestedTimeout.After(now.Add(maxSkew)) {
+		return ErrTimeoutSkewed
+	}
+	return nil
+}
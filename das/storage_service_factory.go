@@ -0,0 +1,227 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// StorageServiceFactory builds one tier of a storage pipeline, wiring next
+// in as the fallback StorageService to consult (or write through to) below
+// this tier. Third parties can add new tiers by calling
+// RegisterStorageServiceFactory without touching
+// NewStorageServiceFromStorageConfig.
+type StorageServiceFactory interface {
+	Name() string
+	Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error)
+}
+
+// ephemeralStorageTiers are caches that may evict data before its configured
+// timeout; they may only sit above (i.e. earlier in storage-tiers than) a
+// persistent tier, never below one.
+var ephemeralStorageTiers = map[string]bool{
+	"big-cache": true,
+	"redis":     true,
+}
+
+var storageServiceFactories = map[string]StorageServiceFactory{}
+
+// RegisterStorageServiceFactory adds f to the set of storage tiers that can
+// be named in storage-tiers. Registering a name that's already registered
+// replaces the existing factory.
+func RegisterStorageServiceFactory(f StorageServiceFactory) {
+	storageServiceFactories[f.Name()] = f
+}
+
+func init() {
+	RegisterStorageServiceFactory(filesStorageServiceFactory{})
+	RegisterStorageServiceFactory(dbStorageServiceFactory{})
+	RegisterStorageServiceFactory(s3StorageServiceFactory{})
+	RegisterStorageServiceFactory(redisStorageServiceFactory{})
+	RegisterStorageServiceFactory(bigCacheStorageServiceFactory{})
+	RegisterStorageServiceFactory(ipfsStorageServiceFactory{filecoin: false})
+	RegisterStorageServiceFactory(ipfsStorageServiceFactory{filecoin: true})
+}
+
+type filesStorageServiceFactory struct{}
+
+func (filesStorageServiceFactory) Name() string { return "files" }
+
+func (filesStorageServiceFactory) Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error) {
+	if next != nil {
+		return nil, fmt.Errorf("files storage tier is terminal and cannot wrap a fallback tier; place it last in storage-tiers")
+	}
+	return NewLocalDiskStorageService(config.LocalConfig.DataDir), nil
+}
+
+type dbStorageServiceFactory struct{}
+
+func (dbStorageServiceFactory) Name() string { return "db" }
+
+func (dbStorageServiceFactory) Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error) {
+	if next != nil {
+		return nil, fmt.Errorf("db storage tier is terminal and cannot wrap a fallback tier; place it last in storage-tiers")
+	}
+	storageService, err := NewDBStorageService(ctx, config.LocalConfig.DataDir, config.DiscardAfterTimeout)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = storageService.Close(context.Background())
+	}()
+	return storageService, nil
+}
+
+type s3StorageServiceFactory struct{}
+
+func (s3StorageServiceFactory) Name() string { return "s3" }
+
+func (s3StorageServiceFactory) Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error) {
+	if next != nil {
+		return nil, fmt.Errorf("s3 storage tier is terminal and cannot wrap a fallback tier; place it last in storage-tiers")
+	}
+	return NewS3StorageService(config.S3Config, config.DiscardAfterTimeout)
+}
+
+type redisStorageServiceFactory struct{}
+
+func (redisStorageServiceFactory) Name() string { return "redis" }
+
+func (redisStorageServiceFactory) Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error) {
+	if next == nil {
+		return nil, fmt.Errorf("redis storage tier requires a fallback tier below it in storage-tiers")
+	}
+	return NewRedisStorageService(config.RedisConfig, next)
+}
+
+type bigCacheStorageServiceFactory struct{}
+
+func (bigCacheStorageServiceFactory) Name() string { return "big-cache" }
+
+func (bigCacheStorageServiceFactory) Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error) {
+	if next == nil {
+		return nil, fmt.Errorf("big-cache storage tier requires a fallback tier below it in storage-tiers")
+	}
+	return NewBigCacheStorageService(config.BigCacheConfig, next)
+}
+
+// ipfsStorageServiceFactory registers as either "ipfs" or "filecoin",
+// matching the two StorageType values NewStorageServiceFromStorageConfig has
+// historically accepted.
+type ipfsStorageServiceFactory struct {
+	filecoin bool
+}
+
+func (f ipfsStorageServiceFactory) Name() string {
+	if f.filecoin {
+		return "filecoin"
+	}
+	return "ipfs"
+}
+
+func (f ipfsStorageServiceFactory) Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error) {
+	if next != nil {
+		return nil, fmt.Errorf("%s storage tier is terminal and cannot wrap a fallback tier; place it last in storage-tiers", f.Name())
+	}
+	ipfsConfig := config.IPFSConfig
+	ipfsConfig.Enable = true
+	ipfsConfig.Filecoin = f.filecoin
+	return NewIPFSStorageService(ipfsConfig)
+}
+
+// validateStorageTiers rejects any unknown tier name and any tier ordering
+// where an ephemeral cache tier (big-cache, redis) is placed below a
+// persistent tier, since data the cache is willing to evict early would
+// otherwise appear to still be backed by the persistent tier's retention
+// guarantees. DiscardAfterTimeout is a single config-wide bool, not set per
+// tier, so this is purely an ordering check, not a per-tier comparison of
+// discard settings.
+func validateStorageTiers(tiers []string) error {
+	seenPersistent := false
+	for _, tier := range tiers {
+		if _, ok := storageServiceFactories[tier]; !ok {
+			return fmt.Errorf("unknown storage tier %q", tier)
+		}
+		if ephemeralStorageTiers[tier] {
+			if seenPersistent {
+				return fmt.Errorf("storage tier %q is ephemeral and cannot be placed below a persistent tier", tier)
+			}
+			continue
+		}
+		seenPersistent = true
+	}
+	return nil
+}
+
+// buildStorageTierPipeline composes config.StorageTiers in order, building
+// each tier with the tier below it (already built) wired in as its
+// fallback, and wrapping each in per-tier metrics.
+func buildStorageTierPipeline(ctx context.Context, config StorageConfig) (StorageService, error) {
+	if err := validateStorageTiers(config.StorageTiers); err != nil {
+		return nil, err
+	}
+
+	var next StorageService
+	for idx := len(config.StorageTiers) - 1; idx >= 0; idx-- {
+		name := config.StorageTiers[idx]
+		factory := storageServiceFactories[name]
+		built, err := factory.Build(ctx, config, next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build storage tier %q: %w", name, err)
+		}
+		next = newMetricsStorageService(name, built)
+	}
+	return next, nil
+}
+
+// metricsStorageService wraps a StorageService with per-tier hit/miss/latency
+// metrics, so operators can tell which tier of a storage-tiers pipeline is
+// actually serving requests.
+type metricsStorageService struct {
+	StorageService
+	tierName    string
+	hitCounter  metrics.Counter
+	missCounter metrics.Counter
+	putTimer    metrics.Timer
+	getTimer    metrics.Timer
+}
+
+func newMetricsStorageService(tierName string, inner StorageService) StorageService {
+	prefix := fmt.Sprintf("das/storagetier/%s/", tierName)
+	return &metricsStorageService{
+		StorageService: inner,
+		tierName:       tierName,
+		hitCounter:     metrics.NewRegisteredCounter(prefix+"hit", nil),
+		missCounter:    metrics.NewRegisteredCounter(prefix+"miss", nil),
+		putTimer:       metrics.NewRegisteredTimer(prefix+"put", nil),
+		getTimer:       metrics.NewRegisteredTimer(prefix+"get", nil),
+	}
+}
+
+func (m *metricsStorageService) Put(ctx context.Context, data []byte, timeout uint64) error {
+	start := time.Now()
+	defer m.putTimer.UpdateSince(start)
+	return m.StorageService.Put(ctx, data, timeout)
+}
+
+func (m *metricsStorageService) GetByHash(ctx context.Context, hash []byte) ([]byte, error) {
+	start := time.Now()
+	defer m.getTimer.UpdateSince(start)
+	data, err := m.StorageService.GetByHash(ctx, hash)
+	if err != nil {
+		m.missCounter.Inc(1)
+		return nil, err
+	}
+	m.hitCounter.Inc(1)
+	return data, nil
+}
+
+func (m *metricsStorageService) String() string {
+	return fmt.Sprintf("metricsStorageService(%s){%v}", m.tierName, m.StorageService)
+}
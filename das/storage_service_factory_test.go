@@ -0,0 +1,102 @@
+// Copyright 2021-2022, Offchain Labs, Inc.
+// For license information, see https://github.com/nitro/blob/master/LICENSE
+
+package das
+
+import (
+	"context"
+	"testing"
+)
+
+func TestValidateStorageTiersUnknownTier(t *testing.T) {
+	if err := validateStorageTiers([]string{"not-a-real-tier"}); err == nil {
+		t.Fatal("expected an error for an unknown storage tier")
+	}
+}
+
+func TestValidateStorageTiersEphemeralBelowPersistent(t *testing.T) {
+	if err := validateStorageTiers([]string{"s3", "redis"}); err == nil {
+		t.Fatal("expected an error when an ephemeral tier is placed below a persistent one")
+	}
+}
+
+func TestValidateStorageTiersEphemeralAbovePersistentIsLegal(t *testing.T) {
+	if err := validateStorageTiers([]string{"redis", "s3"}); err != nil {
+		t.Fatalf("an ephemeral tier above a persistent one should be legal, got %v", err)
+	}
+}
+
+func TestValidateStorageTiersAllPersistentIsLegal(t *testing.T) {
+	if err := validateStorageTiers([]string{"files"}); err != nil {
+		t.Fatalf("a single persistent tier should be legal, got %v", err)
+	}
+}
+
+// stubStorageService is a minimal StorageService used only to stand in as a
+// fallback tier in tests, without depending on a real backend like S3 or
+// Redis, which this package snapshot doesn't have constructors for.
+type stubStorageService struct{}
+
+func (stubStorageService) Put(ctx context.Context, data []byte, timeout uint64) error { return nil }
+func (stubStorageService) GetByHash(ctx context.Context, hash []byte) ([]byte, error) {
+	return nil, ErrNotFound
+}
+func (stubStorageService) Sync(ctx context.Context) error  { return nil }
+func (stubStorageService) Close(ctx context.Context) error { return nil }
+func (stubStorageService) ExpirationPolicy(ctx context.Context) (ExpirationPolicy, error) {
+	return KeepForever, nil
+}
+func (stubStorageService) HealthCheck(ctx context.Context) error { return nil }
+func (stubStorageService) String() string                        { return "stubStorageService" }
+
+// testConsumerStorageServiceFactory is a fake non-ephemeral leaf tier,
+// registered only by this test, that always builds successfully regardless
+// of next, so it's safe to place last in a tier list.
+type testConsumerStorageServiceFactory struct{}
+
+func (testConsumerStorageServiceFactory) Name() string { return "test-consumer" }
+
+func (testConsumerStorageServiceFactory) Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error) {
+	return stubStorageService{}, nil
+}
+
+// testTerminalStorageServiceFactory is a fake leaf tier, registered only by
+// this test, that errors if a fallback tier was wired into it, mirroring
+// files/db/s3/ipfs's real Build methods.
+type testTerminalStorageServiceFactory struct{}
+
+func (testTerminalStorageServiceFactory) Name() string { return "test-terminal" }
+
+func (testTerminalStorageServiceFactory) Build(ctx context.Context, config StorageConfig, next StorageService) (StorageService, error) {
+	if next != nil {
+		return nil, errFakeTerminalTierWrapped
+	}
+	return stubStorageService{}, nil
+}
+
+type errFakeTerminal string
+
+func (e errFakeTerminal) Error() string { return string(e) }
+
+var errFakeTerminalTierWrapped = errFakeTerminal("test-terminal storage tier is terminal and cannot wrap a fallback tier")
+
+func TestBuildStorageTierPipelineRejectsTerminalTierWrappedWithFallback(t *testing.T) {
+	RegisterStorageServiceFactory(testTerminalStorageServiceFactory{})
+	RegisterStorageServiceFactory(testConsumerStorageServiceFactory{})
+
+	// test-terminal is listed before test-consumer, i.e. it is not last, so
+	// it is built with a non-nil fallback and must error.
+	tiers := []string{"test-terminal", "test-consumer"}
+	if _, err := buildStorageTierPipeline(context.Background(), StorageConfig{StorageTiers: tiers}); err == nil {
+		t.Fatal("expected an error building a terminal tier that isn't last in storage-tiers")
+	}
+}
+
+func TestBuildStorageTierPipelineAcceptsTerminalTierLast(t *testing.T) {
+	RegisterStorageServiceFactory(testTerminalStorageServiceFactory{})
+
+	tiers := []string{"test-terminal"}
+	if _, err := buildStorageTierPipeline(context.Background(), StorageConfig{StorageTiers: tiers}); err != nil {
+		t.Fatalf("a terminal tier placed last should build cleanly, got %v", err)
+	}
+}